@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"dns-benchmark/pkg/dnsquery"
+)
+
+// queryFlags holds the query options shared by the benchmark and ping modes.
+type queryFlags struct {
+	noRD             *bool
+	acceptAnySource  *bool
+	maxLatencyFactor *int
+}
+
+func addQueryFlags(fs *flag.FlagSet) queryFlags {
+	return queryFlags{
+		noRD:             fs.Bool("no-rd", false, "Clear the Recursion Desired bit on all queries"),
+		acceptAnySource:  fs.Bool("accept-any-source", false, "Accept UDP replies from addresses other than the queried server"),
+		maxLatencyFactor: fs.Int("max-latency-factor", dnsquery.DefaultMaxLatencyFactor, "Discard samples slower than this multiple of the query timeout"),
+	}
+}
+
+func (f queryFlags) options() (dnsquery.QueryOptions, error) {
+	if *f.maxLatencyFactor < 1 {
+		return dnsquery.QueryOptions{}, fmt.Errorf("-max-latency-factor must be at least 1")
+	}
+	return dnsquery.QueryOptions{
+		RecursionDesired: !*f.noRD,
+		AcceptAnySource:  *f.acceptAnySource,
+		MaxLatencyFactor: *f.maxLatencyFactor,
+	}, nil
+}
+
+// parseArgs parses args with fs and returns the positional arguments. Flags may
+// also follow the positional arguments, as in "8.8.8.8 example.com -no-rd", so
+// parsing resumes after each one.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	fs.Parse(args)
+	var positional []string
+	for fs.NArg() > 0 {
+		positional = append(positional, fs.Arg(0))
+		fs.Parse(fs.Args()[1:])
+	}
+	return positional
+}
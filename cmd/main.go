@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -8,18 +9,41 @@ import (
 )
 
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: dnsbenchmark <dns-server> <query-domain>")
+	if len(os.Args) > 1 && os.Args[1] == "ping" {
+		runPing(os.Args[2:])
+		return
+	}
+
+	qFlags := addQueryFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Println("Usage: dnsbenchmark [-no-rd] [-accept-any-source] [-max-latency-factor N] <dns-server> <query-domain>")
+		fmt.Println("       dnsbenchmark ping [options] <dns-server> <query-domain>")
+		flag.PrintDefaults()
+	}
+	flag.CommandLine.SetOutput(os.Stdout)
+	positional := parseArgs(flag.CommandLine, os.Args[1:])
+
+	if len(positional) != 2 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	dnsServer := os.Args[1]
-	queryDomain := os.Args[2] // Capture the domain from command line
-	results, err := dnsquery.PerformQueries(dnsServer, queryDomain)
+	dnsServer := positional[0]
+	queryDomain, err := dnsquery.NormalizeDomain(positional[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	opts, err := qFlags.options()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	results, err := dnsquery.PerformQueries(dnsServer, queryDomain, opts)
 	if err != nil {
 		fmt.Printf("Failed to perform queries: %v\n", err)
 		os.Exit(1)
 	}
 
-	dnsquery.PrintReport(results, dnsServer, queryDomain)
+	dnsquery.PrintReport(results, dnsServer, dnsquery.DisplayDomain(queryDomain), opts)
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"dns-benchmark/pkg/dnsquery"
+)
+
+func runPing(args []string) {
+	fs := flag.NewFlagSet("ping", flag.ExitOnError)
+	count := fs.Int("n", 0, "Number of queries to send (0 means until interrupted)")
+	interval := fs.Duration("i", time.Second, "Interval between queries")
+	queryType := fs.String("type", "A", "Query type to send")
+	qFlags := addQueryFlags(fs)
+	fs.Usage = func() {
+		fmt.Println("Usage: dnsbenchmark ping [-n count] [-i interval] [-type A] [-no-rd] [-accept-any-source] [-max-latency-factor N] <dns-server> <query-domain>")
+		fs.PrintDefaults()
+	}
+	fs.SetOutput(os.Stdout)
+	positional := parseArgs(fs, args)
+
+	if len(positional) != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *count < 0 || *interval <= 0 {
+		fmt.Println("-n must not be negative and -i must be positive")
+		os.Exit(1)
+	}
+	qType, ok := dns.StringToType[strings.ToUpper(*queryType)]
+	if !ok {
+		fmt.Printf("Unknown query type %q\n", *queryType)
+		os.Exit(1)
+	}
+
+	dnsServer := positional[0]
+	queryDomain, err := dnsquery.NormalizeDomain(positional[1])
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	opts, err := qFlags.options()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	displayDomain := dnsquery.DisplayDomain(queryDomain)
+	fmt.Printf("DNS PING %s (Domain: %s, Type: %s)\n", dnsServer, displayDomain, dns.TypeToString[qType])
+
+	var durations []time.Duration
+	sent, discarded := 0, 0
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+loop:
+	for (*count == 0 || sent < *count) && ctx.Err() == nil {
+		if sent > 0 {
+			select {
+			case <-ctx.Done():
+				break loop
+			case <-ticker.C:
+			}
+		}
+
+		sent++
+		reply, err := dnsquery.PerformQuery(dnsServer, queryDomain, qType, opts)
+		if errors.Is(err, dnsquery.ErrNegativeLatency) || errors.Is(err, dnsquery.ErrImplausibleLatency) {
+			discarded++
+			fmt.Printf("seq=%d discarded: %v\n", sent, err)
+			continue
+		}
+		if err != nil {
+			fmt.Printf("seq=%d error: %v\n", sent, err)
+			continue
+		}
+		durations = append(durations, reply.Duration)
+		line := fmt.Sprintf("seq=%d time=%v", sent, reply.Duration)
+		if reply.Rcode != dns.RcodeSuccess {
+			line += " rcode=" + dns.RcodeToString[reply.Rcode]
+		}
+		if reply.SourceMismatch {
+			line += " (reply from a different address)"
+		}
+		fmt.Println(line)
+	}
+
+	dnsquery.PrintPingStats(dnsquery.CalculatePingStats(sent, discarded, durations), dnsServer, displayDomain)
+}
@@ -2,11 +2,14 @@ module dns-benchmark
 
 go 1.18
 
-require github.com/miekg/dns v1.1.58
+require (
+	github.com/miekg/dns v1.1.58
+	golang.org/x/net v0.20.0
+)
 
 require (
 	golang.org/x/mod v0.14.0 // indirect
-	golang.org/x/net v0.20.0 // indirect
 	golang.org/x/sys v0.16.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 	golang.org/x/tools v0.17.0 // indirect
 )
@@ -1,19 +1,135 @@
 package dnsquery
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/miekg/dns"
+	"golang.org/x/net/idna"
 )
 
+const (
+	queryTimeout = 2 * time.Second
+	// DefaultMaxLatencyFactor is the multiple of the query timeout beyond which
+	// a measured duration can only come from a clock anomaly such as a suspend
+	// mid-query, so the sample is discarded.
+	DefaultMaxLatencyFactor = 5
+)
+
+var (
+	// ErrNegativeLatency is returned by PerformQuery when the measured
+	// duration is negative, which only a clock step can cause.
+	ErrNegativeLatency = errors.New("negative query latency")
+	// ErrImplausibleLatency is returned by PerformQuery when the measured
+	// duration exceeds the maximum latency factor times the query timeout.
+	ErrImplausibleLatency = errors.New("implausible query latency")
+)
+
+// timeSince is replaced in tests to inject pathological durations.
+var timeSince = time.Since
+
+// Underscores are allowed so service labels such as _dmarc.example.com pass;
+// the bidi rule rejects labels mixing right-to-left and left-to-right text.
+var domainProfile = idna.New(idna.MapForLookup(), idna.StrictDomainName(false), idna.BidiRule())
+
 type queryResult struct {
 	QueryType uint16
 	Duration  time.Duration
+	Rcode     int
+}
+
+// QueryOptions controls how the benchmark queries are sent.
+type QueryOptions struct {
+	RecursionDesired bool
+	// AcceptAnySource accepts UDP replies coming from an address other than
+	// the queried server instead of letting them time out.
+	AcceptAnySource bool
+	// MaxLatencyFactor is the multiple of the query timeout beyond which a
+	// sample is discarded. Zero means DefaultMaxLatencyFactor.
+	MaxLatencyFactor int
+}
+
+func (o QueryOptions) maxLatencyFactor() int {
+	if o.MaxLatencyFactor == 0 {
+		return DefaultMaxLatencyFactor
+	}
+	return o.MaxLatencyFactor
+}
+
+// Reply is the outcome of a single timed query.
+type Reply struct {
+	Duration time.Duration
+	Rcode    int
+	// SourceMismatch reports that the reply came from an address other than
+	// the queried server.
+	SourceMismatch bool
+}
+
+// Results holds the timings of a benchmark run.
+type Results struct {
+	Durations map[uint16]time.Duration
+	// Rcodes holds the response code of each reply timed in Durations.
+	Rcodes map[uint16]int
+	// Queries is the number of queries sent.
+	Queries int
+	// SourceMismatches counts replies received from an address other than the
+	// queried server. It is only tracked with QueryOptions.AcceptAnySource.
+	SourceMismatches int
+	// NegativeSamples counts queries whose measured duration was negative.
+	NegativeSamples int
+	// DiscardedSamples counts queries whose duration exceeded the maximum
+	// latency factor. Both kinds of sample are left out of Durations.
+	DiscardedSamples int
+}
+
+// NormalizeDomain validates a user-supplied domain and returns it in its
+// canonical fully qualified ASCII form, converting IDNs to punycode.
+func NormalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSpace(domain)
+	if domain == "" {
+		return "", fmt.Errorf("query domain must not be empty")
+	}
+	ascii, err := domainProfile.ToASCII(domain)
+	if err != nil {
+		return "", fmt.Errorf("invalid query domain %q: %v", domain, err)
+	}
+	if _, ok := dns.IsDomainName(ascii); !ok || strings.IndexFunc(ascii, invalidDomainRune) >= 0 {
+		return "", fmt.Errorf("invalid query domain %q", domain)
+	}
+	return dns.Fqdn(ascii), nil
+}
+
+// DisplayDomain returns the Unicode form of a normalized domain for output,
+// without the trailing dot of the FQDN unless it is the root. It falls back
+// to the ASCII form if the domain cannot be decoded.
+func DisplayDomain(domain string) string {
+	if domain != "." {
+		domain = strings.TrimSuffix(domain, ".")
+	}
+	unicode, err := domainProfile.ToUnicode(domain)
+	if err != nil {
+		return domain
+	}
+	return unicode
+}
+
+func invalidDomainRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	case r == '-' || r == '_' || r == '.':
+		return false
+	}
+	return true
 }
 
-func PerformQueries(dnsServer string, queryDomain string) (map[uint16]time.Duration, error) {
+func PerformQueries(dnsServer string, queryDomain string, opts QueryOptions) (Results, error) {
 	queryTypes := []uint16{
 		dns.TypeA,
 		dns.TypeAAAA,
@@ -22,49 +138,195 @@ func PerformQueries(dnsServer string, queryDomain string) (map[uint16]time.Durat
 		dns.TypeTXT,
 		dns.TypeNS,
 	}
-	results := make(map[uint16]time.Duration)
+	results := Results{
+		Durations: make(map[uint16]time.Duration),
+		Rcodes:    make(map[uint16]int),
+	}
 
 	for _, qType := range queryTypes {
-		duration, err := performDNSQuery(dnsServer, queryDomain, qType)
-		if err != nil {
-			return nil, err
+		reply, err := PerformQuery(dnsServer, queryDomain, qType, opts)
+		results.Queries++
+		if reply.SourceMismatch {
+			results.SourceMismatches++
+		}
+		switch {
+		case errors.Is(err, ErrNegativeLatency):
+			results.NegativeSamples++
+			continue
+		case errors.Is(err, ErrImplausibleLatency):
+			results.DiscardedSamples++
+			continue
+		case err != nil:
+			return Results{}, err
 		}
-		results[qType] = duration
+		results.Durations[qType] = reply.Duration
+		results.Rcodes[qType] = reply.Rcode
 	}
 
 	return results, nil
 }
 
-func performDNSQuery(dnsServer string, queryDomain string, qType uint16) (time.Duration, error) {
-	c := new(dns.Client)
+// PerformQuery times a single query against dnsServer, which may carry a port
+// and otherwise defaults to port 53. When the latency is rejected with
+// ErrNegativeLatency or ErrImplausibleLatency, the returned Reply still
+// describes the reply that was received.
+func PerformQuery(dnsServer string, queryDomain string, qType uint16, opts QueryOptions) (Reply, error) {
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(queryDomain), qType)
-	startTime := time.Now()
-	_, _, err := c.Exchange(m, dnsServer+":53")
+	m.RecursionDesired = opts.RecursionDesired
+	server := serverAddress(dnsServer)
+
+	var reply Reply
+	var resp *dns.Msg
+	var err error
+	if opts.AcceptAnySource {
+		resp, reply.Duration, reply.SourceMismatch, err = exchangeAnySource(m, server)
+	} else {
+		c := &dns.Client{Timeout: queryTimeout}
+		startTime := time.Now()
+		resp, _, err = c.Exchange(m, server)
+		reply.Duration = timeSince(startTime)
+	}
+	if err != nil {
+		return Reply{}, err
+	}
+	reply.Rcode = resp.Rcode
+	if err := checkLatency(reply.Duration, opts.maxLatencyFactor()); err != nil {
+		return reply, fmt.Errorf("%w: %s query took %v", err, dns.TypeToString[qType], reply.Duration)
+	}
+	return reply, nil
+}
+
+func checkLatency(d time.Duration, maxFactor int) error {
+	switch {
+	case d < 0:
+		return ErrNegativeLatency
+	case d > time.Duration(maxFactor)*queryTimeout:
+		return ErrImplausibleLatency
+	}
+	return nil
+}
+
+func serverAddress(dnsServer string) string {
+	if _, _, err := net.SplitHostPort(dnsServer); err == nil {
+		return dnsServer
+	}
+	return net.JoinHostPort(dnsServer, "53")
+}
+
+// exchangeAnySource sends m over an unconnected UDP socket so that a reply
+// from any address is received, and reports whether it came from somewhere
+// other than server. Since the source is not checked, a datagram only counts
+// as the reply if its ID and question match m. The returned duration covers
+// the exchange only, not resolving server or opening the socket.
+func exchangeAnySource(m *dns.Msg, server string) (*dns.Msg, time.Duration, bool, error) {
+	raddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
 	if err != nil {
-		return 0, err
+		return nil, 0, false, err
+	}
+	defer conn.Close()
+	query, err := m.Pack()
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	startTime := time.Now()
+	if err := conn.SetDeadline(startTime.Add(queryTimeout)); err != nil {
+		return nil, 0, false, err
+	}
+	if _, err := conn.WriteToUDP(query, raddr); err != nil {
+		return nil, 0, false, err
+	}
+
+	buf := make([]byte, dns.MaxMsgSize)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		reply := new(dns.Msg)
+		if reply.Unpack(buf[:n]) != nil || !isReplyTo(reply, m) {
+			continue
+		}
+		mismatch := !src.IP.Equal(raddr.IP) || src.Port != raddr.Port
+		return reply, timeSince(startTime), mismatch, nil
+	}
+}
+
+func isReplyTo(reply *dns.Msg, m *dns.Msg) bool {
+	if reply.Id != m.Id || !reply.Response || len(reply.Question) != len(m.Question) {
+		return false
 	}
-	duration := time.Since(startTime)
-	return duration, nil
+	for i, q := range m.Question {
+		r := reply.Question[i]
+		if !strings.EqualFold(r.Name, q.Name) || r.Qtype != q.Qtype || r.Qclass != q.Qclass {
+			return false
+		}
+	}
+	return true
 }
 
-func PrintReport(results map[uint16]time.Duration, dnsServer string, queryDomain string) {
+func PrintReport(results Results, dnsServer string, queryDomain string, opts QueryOptions) {
+	WriteReport(os.Stdout, results, dnsServer, queryDomain, opts)
+}
+
+// WriteReport writes the Markdown timing report to w. Replies other than
+// NOERROR are listed in a separate table, since their timings do not reflect
+// a real answer (e.g. REFUSED from a resolver queried with RD cleared).
+func WriteReport(w io.Writer, results Results, dnsServer string, queryDomain string, opts QueryOptions) {
 	// Convert map to slice for sorting
-	var resultsSlice []queryResult
-	for qType, duration := range results {
-		resultsSlice = append(resultsSlice, queryResult{QueryType: qType, Duration: duration})
+	var answered, failed []queryResult
+	for qType, duration := range results.Durations {
+		result := queryResult{QueryType: qType, Duration: duration, Rcode: results.Rcodes[qType]}
+		if result.Rcode == dns.RcodeSuccess {
+			answered = append(answered, result)
+		} else {
+			failed = append(failed, result)
+		}
 	}
 
-	// Sort slice by duration
-	sort.Slice(resultsSlice, func(i, j int) bool {
-		return resultsSlice[i].Duration < resultsSlice[j].Duration
-	})
+	// Sort slices by duration
+	for _, resultsSlice := range [][]queryResult{answered, failed} {
+		sort.Slice(resultsSlice, func(i, j int) bool {
+			return resultsSlice[i].Duration < resultsSlice[j].Duration
+		})
+	}
 
 	// Print sorted results with DNS server and domain information
-	fmt.Printf("# DNS Query Timing Report for %s (Domain: %s)\n", dnsServer, queryDomain)
-	fmt.Println("| Query Type | Time Taken |")
-	fmt.Println("|------------|------------|")
-	for _, result := range resultsSlice {
-		fmt.Printf("| %s | %v |\n", dns.TypeToString[result.QueryType], result.Duration)
+	details := "Domain: " + queryDomain
+	if !opts.RecursionDesired {
+		details += ", RD cleared"
+	}
+	fmt.Fprintf(w, "# DNS Query Timing Report for %s (%s)\n", dnsServer, details)
+	fmt.Fprintln(w, "| Query Type | Time Taken |")
+	fmt.Fprintln(w, "|------------|------------|")
+	for _, result := range answered {
+		fmt.Fprintf(w, "| %s | %v |\n", dns.TypeToString[result.QueryType], result.Duration)
+	}
+
+	if len(failed) > 0 {
+		fmt.Fprintln(w, "\n## Replies Without an Answer")
+		fmt.Fprintln(w, "| Query Type | Response Code | Time Taken |")
+		fmt.Fprintln(w, "|------------|---------------|------------|")
+		for _, result := range failed {
+			fmt.Fprintf(w, "| %s | %s | %v |\n", dns.TypeToString[result.QueryType], dns.RcodeToString[result.Rcode], result.Duration)
+		}
+	}
+
+	if results.SourceMismatches > 0 {
+		fmt.Fprintf(w, "\n**Warning:** %d of %d replies came from an address other than %s; the server is likely behind a broken NAT.\n",
+			results.SourceMismatches, results.Queries, dnsServer)
+	}
+	if results.NegativeSamples > 0 {
+		fmt.Fprintf(w, "\n**Note:** %d queries were discarded because their measured latency was negative (clock change).\n",
+			results.NegativeSamples)
+	}
+	if results.DiscardedSamples > 0 {
+		fmt.Fprintf(w, "\n**Note:** %d queries were discarded because their measured latency exceeded %d times the %v query timeout (clock change or suspend).\n",
+			results.DiscardedSamples, opts.maxLatencyFactor(), queryTimeout)
 	}
 }
@@ -0,0 +1,345 @@
+package dnsquery
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCheckLatency(t *testing.T) {
+	limit := DefaultMaxLatencyFactor * queryTimeout
+	tests := []struct {
+		name      string
+		d         time.Duration
+		maxFactor int
+		want      error
+	}{
+		{"negative", -time.Nanosecond, DefaultMaxLatencyFactor, ErrNegativeLatency},
+		{"zero", 0, DefaultMaxLatencyFactor, nil},
+		{"at limit", limit, DefaultMaxLatencyFactor, nil},
+		{"over limit", limit + time.Nanosecond, DefaultMaxLatencyFactor, ErrImplausibleLatency},
+		{"over limit with larger factor", limit + time.Nanosecond, DefaultMaxLatencyFactor + 1, nil},
+		{"under limit with smaller factor", queryTimeout + time.Nanosecond, 1, ErrImplausibleLatency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkLatency(tt.d, tt.maxFactor); got != tt.want {
+				t.Errorf("checkLatency(%v, %d) = %v, want %v", tt.d, tt.maxFactor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr bool
+	}{
+		{in: "example.com", want: "example.com."},
+		{in: "example.com.", want: "example.com."},
+		{in: "EXAMPLE.com", want: "example.com."},
+		{in: "_dmarc.example.com", want: "_dmarc.example.com."},
+		{in: "münchen.de", want: "xn--mnchen-3ya.de."},
+		{in: ".", want: "."},
+		{in: "", wantErr: true},
+		{in: "   ", wantErr: true},
+		{in: "a..b", wantErr: true},
+		{in: "*.example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeDomain(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("NormalizeDomain(%q) = %q, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("NormalizeDomain(%q) returned error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeDomain(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeDomainRejectsBidiViolation(t *testing.T) {
+	if got, err := NormalizeDomain("aمb.com"); err == nil {
+		t.Errorf("NormalizeDomain(mixed-direction label) = %q, want error", got)
+	}
+}
+
+func TestDisplayDomain(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"xn--mnchen-3ya.de.", "münchen.de"},
+		{"example.com.", "example.com"},
+		{".", "."},
+		{"xn--zz.com.", "xn--zz.com"},
+	}
+	for _, tt := range tests {
+		if got := DisplayDomain(tt.in); got != tt.want {
+			t.Errorf("DisplayDomain(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+// startResponder listens on two loopback sockets and hands every query
+// received on the first to reply, which writes its answers through either.
+func startResponder(t *testing.T, reply func(q *dns.Msg, src *net.UDPAddr, queried, other *net.UDPConn)) *net.UDPConn {
+	t.Helper()
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	queried, err := net.ListenUDP("udp", loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := net.ListenUDP("udp", loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		queried.Close()
+		other.Close()
+	})
+
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, src, err := queried.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			q := new(dns.Msg)
+			if q.Unpack(buf[:n]) == nil {
+				reply(q, src, queried, other)
+			}
+		}
+	}()
+	return queried
+}
+
+func sendReply(t *testing.T, conn *net.UDPConn, to *net.UDPAddr, q *dns.Msg, id uint16) {
+	r := new(dns.Msg)
+	r.SetReply(q)
+	r.Id = id
+	b, err := r.Pack()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conn.WriteToUDP(b, to); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExchangeAnySource(t *testing.T) {
+	tests := []struct {
+		name     string
+		reply    func(t *testing.T, q *dns.Msg, src *net.UDPAddr, queried, other *net.UDPConn)
+		mismatch bool
+	}{
+		{
+			name: "reply from queried address",
+			reply: func(t *testing.T, q *dns.Msg, src *net.UDPAddr, queried, other *net.UDPConn) {
+				sendReply(t, queried, src, q, q.Id)
+			},
+			mismatch: false,
+		},
+		{
+			name: "reply from other address",
+			reply: func(t *testing.T, q *dns.Msg, src *net.UDPAddr, queried, other *net.UDPConn) {
+				sendReply(t, other, src, q, q.Id)
+			},
+			mismatch: true,
+		},
+		{
+			name: "non-response message is skipped",
+			reply: func(t *testing.T, q *dns.Msg, src *net.UDPAddr, queried, other *net.UDPConn) {
+				query := q.Copy()
+				b, err := query.Pack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				other.WriteToUDP(b, src)
+				sendReply(t, queried, src, q, q.Id)
+			},
+			mismatch: false,
+		},
+		{
+			name: "reply to another question is skipped",
+			reply: func(t *testing.T, q *dns.Msg, src *net.UDPAddr, queried, other *net.UDPConn) {
+				wrong := q.Copy()
+				wrong.Question[0].Qtype = dns.TypeMX
+				sendReply(t, other, src, wrong, q.Id)
+				sendReply(t, queried, src, q, q.Id)
+			},
+			mismatch: false,
+		},
+		{
+			name: "wrong message ID is skipped",
+			reply: func(t *testing.T, q *dns.Msg, src *net.UDPAddr, queried, other *net.UDPConn) {
+				sendReply(t, other, src, q, q.Id+1)
+				sendReply(t, queried, src, q, q.Id)
+			},
+			mismatch: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startResponder(t, func(q *dns.Msg, src *net.UDPAddr, queried, other *net.UDPConn) {
+				tt.reply(t, q, src, queried, other)
+			})
+
+			m := new(dns.Msg)
+			m.SetQuestion("example.com.", dns.TypeA)
+			_, _, mismatch, err := exchangeAnySource(m, server.LocalAddr().String())
+			if err != nil {
+				t.Fatalf("exchangeAnySource returned error: %v", err)
+			}
+			if mismatch != tt.mismatch {
+				t.Errorf("mismatch = %v, want %v", mismatch, tt.mismatch)
+			}
+		})
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	results := Results{
+		Durations: map[uint16]time.Duration{
+			dns.TypeA:  time.Millisecond,
+			dns.TypeMX: 2 * time.Millisecond,
+		},
+		Rcodes: map[uint16]int{
+			dns.TypeA:  dns.RcodeSuccess,
+			dns.TypeMX: dns.RcodeRefused,
+		},
+	}
+
+	var recursive bytes.Buffer
+	WriteReport(&recursive, results, "192.0.2.1", "example.com", QueryOptions{RecursionDesired: true})
+	if strings.Contains(recursive.String(), "RD cleared") {
+		t.Errorf("recursive report is labelled RD cleared:\n%s", recursive.String())
+	}
+
+	var buf bytes.Buffer
+	WriteReport(&buf, results, "192.0.2.1", "example.com", QueryOptions{})
+	out := buf.String()
+	if !strings.Contains(out, "# DNS Query Timing Report for 192.0.2.1 (Domain: example.com, RD cleared)") {
+		t.Errorf("header does not mention RD cleared:\n%s", out)
+	}
+	answered, failed, ok := strings.Cut(out, "## Replies Without an Answer")
+	if !ok {
+		t.Fatalf("report has no table for replies without an answer:\n%s", out)
+	}
+	if !strings.Contains(answered, "| A | 1ms |") || strings.Contains(answered, "| MX |") {
+		t.Errorf("main table should list only NOERROR replies:\n%s", answered)
+	}
+	if !strings.Contains(failed, "| MX | REFUSED | 2ms |") {
+		t.Errorf("REFUSED reply missing from separate table:\n%s", failed)
+	}
+}
+
+// injectDurations makes each timed exchange report the next of durations.
+func injectDurations(t *testing.T, durations ...time.Duration) {
+	t.Helper()
+	var mu sync.Mutex
+	timeSince = func(time.Time) time.Duration {
+		mu.Lock()
+		defer mu.Unlock()
+		d := durations[0]
+		durations = durations[1:]
+		return d
+	}
+	t.Cleanup(func() { timeSince = time.Since })
+}
+
+func TestPerformQueriesDiscardsPathologicalLatencies(t *testing.T) {
+	server := startResponder(t, func(q *dns.Msg, src *net.UDPAddr, queried, other *net.UDPConn) {
+		sendReply(t, queried, src, q, q.Id)
+	})
+	ms := time.Millisecond
+	overLimit := DefaultMaxLatencyFactor*queryTimeout + time.Nanosecond
+
+	tests := []struct {
+		name          string
+		opts          QueryOptions
+		wantDurations int
+		wantNegative  int
+		wantDiscarded int
+	}{
+		{"default factor", QueryOptions{RecursionDesired: true}, 4, 1, 1},
+		{"larger factor keeps slow sample", QueryOptions{RecursionDesired: true, MaxLatencyFactor: DefaultMaxLatencyFactor + 1}, 5, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injectDurations(t, -time.Nanosecond, ms, overLimit, 2*ms, 3*ms, 4*ms)
+			results, err := PerformQueries(server.LocalAddr().String(), "example.com.", tt.opts)
+			if err != nil {
+				t.Fatalf("PerformQueries returned error: %v", err)
+			}
+			if len(results.Durations) != tt.wantDurations {
+				t.Errorf("got %d timings, want %d: %v", len(results.Durations), tt.wantDurations, results.Durations)
+			}
+			if results.NegativeSamples != tt.wantNegative {
+				t.Errorf("NegativeSamples = %d, want %d", results.NegativeSamples, tt.wantNegative)
+			}
+			if results.DiscardedSamples != tt.wantDiscarded {
+				t.Errorf("DiscardedSamples = %d, want %d", results.DiscardedSamples, tt.wantDiscarded)
+			}
+			for qType, d := range results.Durations {
+				if d < 0 || d > time.Duration(tt.opts.maxLatencyFactor())*queryTimeout {
+					t.Errorf("%s kept implausible duration %v", dns.TypeToString[qType], d)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteReportDiscardNotes(t *testing.T) {
+	results := Results{NegativeSamples: 1, DiscardedSamples: 2}
+	var buf bytes.Buffer
+	WriteReport(&buf, results, "192.0.2.1", "example.com", QueryOptions{RecursionDesired: true, MaxLatencyFactor: 3})
+	out := buf.String()
+	for _, want := range []string{
+		"1 queries were discarded because their measured latency was negative",
+		"2 queries were discarded because their measured latency exceeded 3 times the 2s query timeout",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestPerformQueriesCountsMismatchOnDiscardedSample(t *testing.T) {
+	server := startResponder(t, func(q *dns.Msg, src *net.UDPAddr, queried, other *net.UDPConn) {
+		sendReply(t, other, src, q, q.Id)
+	})
+	ms := time.Millisecond
+	injectDurations(t, ms, ms, DefaultMaxLatencyFactor*queryTimeout+time.Nanosecond, ms, ms, ms)
+
+	opts := QueryOptions{RecursionDesired: true, AcceptAnySource: true}
+	results, err := PerformQueries(server.LocalAddr().String(), "example.com.", opts)
+	if err != nil {
+		t.Fatalf("PerformQueries returned error: %v", err)
+	}
+	if results.Queries != 6 || results.DiscardedSamples != 1 || results.SourceMismatches != 6 {
+		t.Fatalf("Queries = %d, DiscardedSamples = %d, SourceMismatches = %d, want 6, 1, 6",
+			results.Queries, results.DiscardedSamples, results.SourceMismatches)
+	}
+
+	var buf bytes.Buffer
+	WriteReport(&buf, results, "192.0.2.1", "example.com", opts)
+	if !strings.Contains(buf.String(), "6 of 6 replies came from an address other than 192.0.2.1") {
+		t.Errorf("warning does not count all queries sent:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,72 @@
+package dnsquery
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// PingStats summarizes a series of repeated queries to one server.
+type PingStats struct {
+	Sent     int
+	Received int
+	// Discarded counts replies whose latency was rejected as implausible. They
+	// were answered, so they count neither as received nor as lost.
+	Discarded int
+	Min       time.Duration
+	Max       time.Duration
+	Avg       time.Duration
+	StdDev    time.Duration
+}
+
+// CalculatePingStats computes ping statistics from the durations of the
+// successful replies out of sent queries, discarded of which had their
+// latency rejected.
+func CalculatePingStats(sent int, discarded int, durations []time.Duration) PingStats {
+	stats := PingStats{Sent: sent, Received: len(durations), Discarded: discarded}
+	if len(durations) == 0 {
+		return stats
+	}
+
+	var sum time.Duration
+	stats.Min = durations[0]
+	for _, d := range durations {
+		sum += d
+		if d < stats.Min {
+			stats.Min = d
+		}
+		if d > stats.Max {
+			stats.Max = d
+		}
+	}
+	stats.Avg = sum / time.Duration(len(durations))
+
+	var variance float64
+	for _, d := range durations {
+		diff := float64(d - stats.Avg)
+		variance += diff * diff
+	}
+	stats.StdDev = time.Duration(math.Sqrt(variance / float64(len(durations))))
+	return stats
+}
+
+// Loss returns the percentage of queries that got no usable reply.
+func (s PingStats) Loss() float64 {
+	counted := s.Sent - s.Discarded
+	if counted <= 0 {
+		return 0
+	}
+	return float64(counted-s.Received) / float64(counted) * 100
+}
+
+func PrintPingStats(stats PingStats, dnsServer string, queryDomain string) {
+	fmt.Printf("\n--- %s DNS ping statistics (Domain: %s) ---\n", dnsServer, queryDomain)
+	fmt.Printf("%d queries sent, %d replies received, ", stats.Sent, stats.Received)
+	if stats.Discarded > 0 {
+		fmt.Printf("%d discarded, ", stats.Discarded)
+	}
+	fmt.Printf("%.1f%% loss\n", stats.Loss())
+	if stats.Received > 0 {
+		fmt.Printf("min/avg/max/stddev = %v/%v/%v/%v\n", stats.Min, stats.Avg, stats.Max, stats.StdDev)
+	}
+}
@@ -0,0 +1,70 @@
+package dnsquery
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculatePingStats(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name      string
+		sent      int
+		discarded int
+		durations []time.Duration
+		want      PingStats
+		wantLoss  float64
+	}{
+		{
+			name:     "nothing sent",
+			want:     PingStats{},
+			wantLoss: 0,
+		},
+		{
+			name:     "zero replies",
+			sent:     3,
+			want:     PingStats{Sent: 3},
+			wantLoss: 100,
+		},
+		{
+			name:      "some loss",
+			sent:      4,
+			durations: []time.Duration{10 * ms, 30 * ms},
+			want:      PingStats{Sent: 4, Received: 2, Min: 10 * ms, Max: 30 * ms, Avg: 20 * ms, StdDev: 10 * ms},
+			wantLoss:  50,
+		},
+		{
+			name:      "discarded samples are not loss",
+			sent:      4,
+			discarded: 2,
+			durations: []time.Duration{10 * ms, 30 * ms},
+			want:      PingStats{Sent: 4, Received: 2, Discarded: 2, Min: 10 * ms, Max: 30 * ms, Avg: 20 * ms, StdDev: 10 * ms},
+			wantLoss:  0,
+		},
+		{
+			name:      "all discarded",
+			sent:      2,
+			discarded: 2,
+			want:      PingStats{Sent: 2, Discarded: 2},
+			wantLoss:  0,
+		},
+		{
+			name:      "identical replies",
+			sent:      3,
+			durations: []time.Duration{5 * ms, 5 * ms, 5 * ms},
+			want:      PingStats{Sent: 3, Received: 3, Min: 5 * ms, Max: 5 * ms, Avg: 5 * ms},
+			wantLoss:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CalculatePingStats(tt.sent, tt.discarded, tt.durations)
+			if got != tt.want {
+				t.Errorf("CalculatePingStats() = %+v, want %+v", got, tt.want)
+			}
+			if loss := got.Loss(); loss != tt.wantLoss {
+				t.Errorf("Loss() = %v, want %v", loss, tt.wantLoss)
+			}
+		})
+	}
+}